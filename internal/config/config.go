@@ -6,23 +6,42 @@ import (
 	"path/filepath"
 
 	"github.com/charmbracelet/log"
+	"github.com/tsukinoko-kun/portal/internal/discovery"
 )
 
 var (
-	Addr  string
-	Path  string
-	Debug bool
+	Addr          string
+	Path          string
+	Debug         bool
+	DiscoveryAddr string
+	NoDiscovery   bool
+	NoTLS         bool
+	Cert          string
+	Key           string
+	Mode          string
 )
 
 func init() {
 	port := flag.Int("port", 0, "port to listen on")
 	flag.StringVar(&Path, "path", ".", "path to serve")
 	flag.BoolVar(&Debug, "debug", false, "enable debug logging")
+	flag.StringVar(&DiscoveryAddr, "discovery-addr", discovery.DefaultAddr, "multicast address:port used for LAN peer discovery")
+	flag.BoolVar(&NoDiscovery, "no-discovery", false, "disable answering LAN peer discovery probes")
+	flag.BoolVar(&NoTLS, "no-tls", false, "disable HTTPS and serve plain HTTP")
+	flag.StringVar(&Cert, "cert", "", "path to a TLS certificate to serve, instead of the generated one")
+	flag.StringVar(&Key, "key", "", "path to the private key for --cert")
+	flag.StringVar(&Mode, "mode", "receive", "transfer mode: send, receive, or both")
 
 	flag.Parse()
 
 	Addr = fmt.Sprintf(":%d", *port)
 
+	switch Mode {
+	case "send", "receive", "both":
+	default:
+		log.Fatal("invalid --mode, must be one of send, receive, both", "mode", Mode)
+	}
+
 	if p, err := filepath.Abs(Path); err == nil {
 		Path = p
 	} else {
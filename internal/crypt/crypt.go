@@ -0,0 +1,169 @@
+// Package crypt derives a per-transfer symmetric key from a short code
+// phrase and uses it to seal the binary frames exchanged over the
+// upload WebSocket, so a passive LAN observer sees nothing but
+// ciphertext.
+//
+// The key comes from an ECDH(P-256) exchange whose shared secret is
+// mixed with the code phrase through HKDF-SHA256. Both ends marshal
+// their public key as an uncompressed SEC1 point and run the same
+// HKDF, which is how public/index.js derives a matching key without
+// sharing any extra wire format. Folding the code phrase into the HKDF
+// input keying material means a passive eavesdropper who records the
+// whole exchange still needs to solve the ECDH discrete log before
+// they can even attempt to brute-force the code phrase offline.
+package crypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	dirRecipientToSender byte = 0
+	dirSenderToRecipient byte = 1
+)
+
+// Session wraps an AES-GCM AEAD keyed from the ECDH+codePhrase exchange.
+// Outgoing frames are tagged with a direction byte and a monotonic
+// counter so this peer never reuses a nonce under the shared key;
+// incoming frames carry their own nonce, which Decrypt reads back off
+// the wire.
+type Session struct {
+	aead    cipher.AEAD
+	salt    [3]byte
+	sendDir byte
+	sendCtr uint64
+}
+
+// GenerateCodePhrase returns a short, human-friendly code phrase for the
+// receiving browser to type in. It is folded into the session key, so it
+// must be shared out-of-band (e.g. read aloud, or scanned from a QR
+// code) rather than sent over the connection being established.
+func GenerateCodePhrase() (string, error) {
+	var b [5]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", fmt.Errorf("failed to generate code phrase: %w", err)
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b[:]), nil
+}
+
+// NewRecipientSession runs the "Recipient" side of an ECDH(P-256)
+// exchange with the browser ("Sender") over conn, then derives a 32-byte
+// session key from the shared secret and codePhrase. conn must not have
+// any application data written to or read from it yet, since the
+// handshake owns the first two frames.
+func NewRecipientSession(conn *websocket.Conn, codePhrase string) (*Session, error) {
+	curve := ecdh.P256()
+
+	recipientKey, err := curve.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate ECDH key: %w", err)
+	}
+
+	_, senderPubBytes, err := conn.ReadMessage()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sender's public key: %w", err)
+	}
+	senderPub, err := curve.NewPublicKey(senderPubBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse sender's public key: %w", err)
+	}
+
+	recipientPubBytes := recipientKey.PublicKey().Bytes()
+	if err := conn.WriteMessage(websocket.BinaryMessage, recipientPubBytes); err != nil {
+		return nil, fmt.Errorf("failed to write recipient's public key: %w", err)
+	}
+
+	sharedSecret, err := recipientKey.ECDH(senderPub)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute shared secret: %w", err)
+	}
+
+	key := deriveKey(sharedSecret, senderPubBytes, recipientPubBytes, codePhrase)
+	return newSession(key, dirRecipientToSender)
+}
+
+// deriveKey combines the ECDH shared secret with the code phrase via
+// HKDF-SHA256 to produce a 32-byte AES-256 key. The salt is the two
+// parties' public keys in sender-then-recipient order, which both sides
+// can reconstruct identically without exchanging anything extra.
+func deriveKey(sharedSecret, senderPub, recipientPub []byte, codePhrase string) []byte {
+	ikm := append(append([]byte{}, sharedSecret...), codePhrase...)
+	salt := append(append([]byte{}, senderPub...), recipientPub...)
+	return hkdfSHA256(ikm, salt, []byte("portal session key"), 32)
+}
+
+// hkdfSHA256 implements RFC 5869 HKDF-Extract-and-Expand with SHA-256,
+// matching the Web Crypto "HKDF" algorithm used on the browser side.
+func hkdfSHA256(ikm, salt, info []byte, length int) []byte {
+	extract := hmac.New(sha256.New, salt)
+	extract.Write(ikm)
+	prk := extract.Sum(nil)
+
+	var t, okm []byte
+	for i := byte(1); len(okm) < length; i++ {
+		mac := hmac.New(sha256.New, prk)
+		mac.Write(t)
+		mac.Write(info)
+		mac.Write([]byte{i})
+		t = mac.Sum(nil)
+		okm = append(okm, t...)
+	}
+	return okm[:length]
+}
+
+func newSession(key []byte, sendDir byte) (*Session, error) {
+	block, err := aes.NewCipher(key[:32])
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AES cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AEAD: %w", err)
+	}
+
+	s := &Session{aead: aead, sendDir: sendDir}
+	if _, err := rand.Read(s.salt[:]); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce salt: %w", err)
+	}
+	return s, nil
+}
+
+// nonce builds the 12-byte GCM nonce for an outgoing frame: a per-session
+// random salt followed by this peer's direction tag and its counter, so
+// the two directions never share a nonce under the same key.
+func (s *Session) nonce(counter uint64) []byte {
+	n := make([]byte, s.aead.NonceSize())
+	copy(n[:3], s.salt[:])
+	n[3] = s.sendDir
+	binary.BigEndian.PutUint64(n[4:], counter)
+	return n
+}
+
+// Encrypt seals plaintext for the wire, prefixing the ciphertext with the
+// nonce that was used so the peer can reconstruct it.
+func (s *Session) Encrypt(plaintext []byte) []byte {
+	counter := atomic.AddUint64(&s.sendCtr, 1)
+	nonce := s.nonce(counter)
+	return s.aead.Seal(nonce, nonce, plaintext, nil)
+}
+
+// Decrypt opens a frame produced by the peer's Encrypt.
+func (s *Session) Decrypt(frame []byte) ([]byte, error) {
+	n := s.aead.NonceSize()
+	if len(frame) < n {
+		return nil, errors.New("frame too short to contain a nonce")
+	}
+	return s.aead.Open(nil, frame[:n], frame[n:], nil)
+}
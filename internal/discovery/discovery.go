@@ -0,0 +1,130 @@
+// Package discovery implements the LAN peer-discovery probe/response
+// protocol portal uses so a browser or the `portal discover` CLI can
+// find a running portal without needing the printed URL.
+package discovery
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// DefaultAddr is the multicast group and port portal listens on and
+// probes by default, in the organization-local multicast range.
+const DefaultAddr = "239.255.250.250:60123"
+
+// probeMessage is the payload a `portal discover` client broadcasts to
+// solicit Peer responses.
+const probeMessage = "portal-discover"
+
+// Peer describes a portal instance answering a discovery probe.
+type Peer struct {
+	Name           string `json:"name"`
+	Port           int    `json:"port"`
+	CodePhraseHash string `json:"code_phrase_hash"`
+}
+
+// HashCodePhrase returns the SHA-256 hex digest of a code phrase. It is
+// what gets advertised in a probe response, so the phrase itself never
+// leaves the machine before the receiver has typed it in.
+func HashCodePhrase(codePhrase string) string {
+	sum := sha256.Sum256([]byte(codePhrase))
+	return hex.EncodeToString(sum[:])
+}
+
+// Listen answers discovery probes sent to addr until ctx is cancelled,
+// replying with a Peer describing this portal instance.
+func Listen(ctx context.Context, addr string, port int, codePhraseHash string) error {
+	udpAddr, err := net.ResolveUDPAddr("udp4", addr)
+	if err != nil {
+		return fmt.Errorf("failed to resolve discovery address: %w", err)
+	}
+
+	conn, err := net.ListenMulticastUDP("udp4", nil, udpAddr)
+	if err != nil {
+		return fmt.Errorf("failed to listen for discovery probes: %w", err)
+	}
+
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	name, err := os.Hostname()
+	if err != nil {
+		name = "portal"
+	}
+	payload, err := json.Marshal(Peer{Name: name, Port: port, CodePhraseHash: codePhraseHash})
+	if err != nil {
+		return fmt.Errorf("failed to marshal peer payload: %w", err)
+	}
+
+	buf := make([]byte, 1024)
+	for {
+		n, src, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			log.Error("failed to read discovery probe", "err", err)
+			continue
+		}
+
+		if string(buf[:n]) != probeMessage {
+			continue
+		}
+
+		if _, err := conn.WriteToUDP(payload, src); err != nil {
+			log.Error("failed to reply to discovery probe", "err", err)
+		}
+	}
+}
+
+// Probe broadcasts a discovery probe to addr and collects every Peer
+// that replies before timeout elapses.
+func Probe(addr string, timeout time.Duration) ([]Peer, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp4", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve discovery address: %w", err)
+	}
+
+	// An unconnected socket, since replies come from each peer's own
+	// unicast address rather than from the multicast group probed.
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open discovery socket: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.WriteToUDP([]byte(probeMessage), udpAddr); err != nil {
+		return nil, fmt.Errorf("failed to send discovery probe: %w", err)
+	}
+
+	if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, fmt.Errorf("failed to set read deadline: %w", err)
+	}
+
+	var peers []Peer
+	buf := make([]byte, 1024)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			break
+		}
+
+		var p Peer
+		if err := json.Unmarshal(buf[:n], &p); err != nil {
+			continue
+		}
+		peers = append(peers, p)
+	}
+
+	return peers, nil
+}
@@ -2,20 +2,31 @@ package net
 
 import (
 	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
+	"mime"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/charmbracelet/log"
 	"github.com/gorilla/websocket"
 	"github.com/tsukinoko-kun/portal/internal/config"
+	"github.com/tsukinoko-kun/portal/internal/crypt"
+	"github.com/tsukinoko-kun/portal/internal/discovery"
 	"github.com/tsukinoko-kun/portal/internal/public"
+	"github.com/tsukinoko-kun/portal/internal/tlscert"
 )
 
 type (
@@ -28,16 +39,56 @@ type (
 		LastModified int64 `json:"lastModified"`
 		// Mime contains the MIME type of the file
 		Mime string `json:"mime"`
+		// Sha256 is the hex SHA-256 digest of the first Offset bytes of
+		// the file, sent to verify a resumed upload against the partial
+		// file already on disk. Unused when Offset is 0.
+		Sha256 string `json:"sha256"`
+		// Offset is the byte offset the client wants to resume from, or
+		// 0 to start the file fresh.
+		Offset int64 `json:"offset"`
+		// FullSha256 is the hex SHA-256 digest of the whole file,
+		// checked against the completed upload once every chunk has
+		// been written, so corruption in the newly-streamed tail (which
+		// the prefix hash in Sha256 can't see) doesn't ship as success.
+		FullSha256 string `json:"fullSha256"`
 	}
 )
 
+// upgrader deliberately does not negotiate permessage-deflate: every
+// header and chunk frame UploadHandler reads off conn is AES-GCM
+// ciphertext, which is
+// indistinguishable from random and does not compress, so enabling
+// compression here would only spend CPU for nothing. Don't re-add it
+// without first moving compression to before encryption.
 var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool {
 		return true // Allow all origins; customize this as needed
 	},
 }
 
+// codePhrase is required as the "code" query parameter on /ws, /dl/,
+// and /api/ls, and is folded into the session key derived for /ws by
+// internal/crypt. Generated once in StartServer.
+var codePhrase string
+
+// writeReply encrypts text with session and sends it as a binary frame.
+// UploadHandler's control replies (READY/RESUME/errors) carry file
+// names and path hints, so they get the same AES-GCM seal as headers
+// and chunks instead of going out in the clear.
+func writeReply(conn *websocket.Conn, session *crypt.Session, text string) {
+	if err := conn.WriteMessage(websocket.BinaryMessage, session.Encrypt([]byte(text))); err != nil {
+		log.Error("failed to write encrypted reply", "err", err)
+	}
+}
+
 func UploadHandler(w http.ResponseWriter, r *http.Request) {
+	// Require the printed code phrase before even attempting the key
+	// exchange, so a guesser without it never gets a chance at the
+	// cryptographic exchange.
+	if !requireCodePhrase(w, r) {
+		return
+	}
+
 	// Upgrade the connection to WebSocket
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
@@ -47,6 +98,12 @@ func UploadHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	defer conn.Close()
 
+	session, err := crypt.NewRecipientSession(conn, codePhrase)
+	if err != nil {
+		log.Error("failed to establish encrypted session", "err", err)
+		return
+	}
+
 	for {
 		// Step 1: Receive and decode the file header
 		var header Header
@@ -62,6 +119,12 @@ func UploadHandler(w http.ResponseWriter, r *http.Request) {
 			break
 		}
 
+		message, err = session.Decrypt(message)
+		if err != nil {
+			log.Error("failed to decrypt header", "err", err)
+			return
+		}
+
 		if err := json.Unmarshal(message, &header); err != nil {
 			log.Error("failed to unmarshal header", "err", err)
 			return
@@ -73,43 +136,75 @@ func UploadHandler(w http.ResponseWriter, r *http.Request) {
 		// Ensure the file path is within the server's working directory
 		if !isInWorkingDir(filePath) {
 			log.Error("file path outside working directory")
-			conn.WriteMessage(websocket.TextMessage, []byte("File path outside working directory"))
+			writeReply(conn, session, "File path outside working directory")
 			return
 		}
 
 		// Create the target directory if needed
 		if err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm); err != nil {
 			log.Error("failed to create directory", "err", err)
-			conn.WriteMessage(websocket.TextMessage, []byte("Directory creation error"))
+			writeReply(conn, session, "Directory creation error")
 			return
 		}
 
-		// Open the target file for writing
-		file, err := os.Create(filePath)
+		// Check whether a partial upload at filePath can be resumed
+		resumeOffset, mismatch, err := checkResume(filePath, header)
 		if err != nil {
-			log.Error("failed to create file", "err", err)
-			conn.WriteMessage(websocket.TextMessage, []byte("File creation error"))
+			log.Error("failed to check resumable upload", "err", err)
+			writeReply(conn, session, "Resume check error")
+			return
+		}
+		if mismatch {
+			log.Warn("partial upload does not match resume request, discarding it", "file", filePath)
+			if err := os.Truncate(filePath, 0); err != nil && !os.IsNotExist(err) {
+				log.Error("failed to truncate mismatched partial upload", "err", err)
+			}
+			writeReply(conn, session, "Resume mismatch, restart upload")
 			return
 		}
-		defer file.Close()
 
-		// Send "READY" message to the client
-		conn.WriteMessage(websocket.TextMessage, []byte("READY"))
+		var file *os.File
+		if resumeOffset > 0 {
+			// Open the partial file for appending
+			file, err = os.OpenFile(filePath, os.O_APPEND|os.O_WRONLY, os.ModePerm)
+			if err != nil {
+				log.Error("failed to open file for resume", "err", err)
+				writeReply(conn, session, "File open error")
+				return
+			}
+			writeReply(conn, session, fmt.Sprintf("RESUME %d", resumeOffset))
+		} else {
+			// Open the target file for writing
+			file, err = os.Create(filePath)
+			if err != nil {
+				log.Error("failed to create file", "err", err)
+				writeReply(conn, session, "File creation error")
+				return
+			}
+			writeReply(conn, session, "READY")
+		}
+		defer file.Close()
 
 		// Step 2: Receive and write file chunks
 		for {
-			_, message, err := conn.ReadMessage()
+			ty, message, err := conn.ReadMessage()
 			if err != nil {
 				log.Error("failed to read message", "err", err)
 				return
 			}
 
 			// Check for EOF
-			if string(message) == "EOF" {
+			if ty == websocket.TextMessage && string(message) == "EOF" {
 				log.Debug("end of file")
 				break
 			}
 
+			message, err = session.Decrypt(message)
+			if err != nil {
+				log.Error("failed to decrypt chunk", "err", err)
+				return
+			}
+
 			// Write the chunk to the file
 			if _, err := file.Write(message); err != nil {
 				log.Error("failed to write file chunk", "err", err)
@@ -123,10 +218,85 @@ func UploadHandler(w http.ResponseWriter, r *http.Request) {
 			log.Error("failed to set last modified time", "err", err)
 		}
 
-		log.Info("file copy successful", "file", filePath)
+		sum, err := sha256File(filePath)
+		if err != nil {
+			log.Error("failed to hash completed upload", "err", err)
+			return
+		}
+
+		if header.FullSha256 != "" && sum != header.FullSha256 {
+			log.Error("completed upload failed integrity check, discarding it", "file", filePath)
+			if err := os.Truncate(filePath, 0); err != nil && !os.IsNotExist(err) {
+				log.Error("failed to truncate corrupted upload", "err", err)
+			}
+			writeReply(conn, session, "Integrity check failed, file truncated")
+			return
+		}
+
+		log.Info("file copy successful", "file", filePath, "sha256", sum)
+		writeReply(conn, session, "OK")
 	}
 }
 
+// checkResume inspects any partial upload already at filePath against
+// header and reports the offset to resume from. It returns offset 0
+// with mismatch false for a fresh upload (no partial file, or the
+// client didn't ask to resume, or the partial file's size differs from
+// header.Offset); it returns mismatch true if a partial file of exactly
+// header.Offset bytes exists but its prefix hash doesn't match header,
+// meaning the caller should discard it and start over.
+func checkResume(filePath string, header Header) (offset int64, mismatch bool, err error) {
+	if header.Offset <= 0 {
+		return 0, false, nil
+	}
+
+	info, statErr := os.Stat(filePath)
+	if statErr != nil || info.Size() != header.Offset {
+		return 0, false, nil
+	}
+
+	sum, err := sha256Prefix(filePath, header.Offset)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to hash partial file: %w", err)
+	}
+	if sum != header.Sha256 {
+		return 0, true, nil
+	}
+
+	return header.Offset, false, nil
+}
+
+// sha256Prefix returns the hex SHA-256 digest of the first n bytes of
+// the file at path.
+func sha256Prefix(path string, n int64) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.CopyN(h, f, n); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// sha256File returns the hex SHA-256 digest of the whole file at path.
+func sha256File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
 // normalizePath cleans and returns the absolute path of the file.
 func normalizePath(name string) string {
 	return filepath.Join(config.Path, filepath.Clean(name))
@@ -140,16 +310,131 @@ func isInWorkingDir(path string) bool {
 		return false
 	}
 	relPath, err := filepath.Rel(wd, path)
-	if err != nil || relPath == ".." || relPath == "." || relPath[0] == '/' || filepath.IsAbs(relPath) {
+	if err != nil || relPath == "." || filepath.IsAbs(relPath) {
+		return false
+	}
+	if relPath == ".." || strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
 		return false
 	}
 	return true
 }
 
+// isInWorkingDirOrRoot is isInWorkingDir but also allows the working
+// directory itself, for browsing/listing it at its root.
+func isInWorkingDirOrRoot(path string) bool {
+	wd, err := os.Getwd()
+	if err != nil {
+		log.Error("failed to get working directory", "err", err)
+		return false
+	}
+	if filepath.Clean(path) == wd {
+		return true
+	}
+	return isInWorkingDir(path)
+}
+
+// requireCodePhrase reports whether r carries the "code" query parameter
+// matching this run's code phrase, writing a 401 and returning false if
+// not. send/both mode exposes the whole working directory for browsing
+// and download, so it needs the same gate /ws uses rather than being
+// open to anyone on the LAN.
+func requireCodePhrase(w http.ResponseWriter, r *http.Request) bool {
+	if subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("code")), []byte(codePhrase)) != 1 {
+		log.Error("rejected request with wrong or missing code phrase", "path", r.URL.Path)
+		w.WriteHeader(http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// DownloadHandler serves files rooted at config.Path for download,
+// rejecting any request whose path would escape it.
+func DownloadHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireCodePhrase(w, r) {
+		return
+	}
+
+	filePath := normalizePath(strings.TrimPrefix(r.URL.Path, "/dl/"))
+
+	if !isInWorkingDirOrRoot(filePath) {
+		log.Error("download path outside working directory", "path", filePath)
+		http.Error(w, "path outside working directory", http.StatusForbidden)
+		return
+	}
+
+	http.ServeFile(w, r, filePath)
+}
+
+// ListHandler returns a JSON directory listing, as []Header, for the
+// directory named by the "path" query parameter, rooted at config.Path.
+func ListHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireCodePhrase(w, r) {
+		return
+	}
+
+	dirPath := normalizePath(r.URL.Query().Get("path"))
+
+	if !isInWorkingDirOrRoot(dirPath) {
+		log.Error("list path outside working directory", "path", dirPath)
+		http.Error(w, "path outside working directory", http.StatusForbidden)
+		return
+	}
+
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		log.Error("failed to read directory", "err", err)
+		http.Error(w, "failed to read directory", http.StatusInternalServerError)
+		return
+	}
+
+	headers := make([]Header, 0, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			log.Error("failed to stat directory entry", "err", err)
+			continue
+		}
+		headers = append(headers, Header{
+			Name:         entry.Name(),
+			Size:         int(info.Size()),
+			LastModified: info.ModTime().UnixMilli(),
+			Mime:         mime.TypeByExtension(filepath.Ext(entry.Name())),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(headers); err != nil {
+		log.Error("failed to encode directory listing", "err", err)
+	}
+}
+
+// ModeHandler reports config.Mode so the embedded UI can probe it to
+// decide whether to show the upload view, the browse/download view, or
+// both.
+func ModeHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]string{"mode": config.Mode}); err != nil {
+		log.Error("failed to encode mode", "err", err)
+	}
+}
+
 // StartServer starts the WebSocket server, prints IP/port, and handles graceful shutdown.
 func StartServer() error {
+	var err error
+	if codePhrase, err = crypt.GenerateCodePhrase(); err != nil {
+		return fmt.Errorf("failed to generate code phrase: %v", err)
+	}
+
 	mux := http.NewServeMux()
-	mux.HandleFunc("/ws", UploadHandler)
+	if config.Mode == "receive" || config.Mode == "both" {
+		mux.HandleFunc("/ws", UploadHandler)
+	}
+	if config.Mode == "send" || config.Mode == "both" {
+		mux.HandleFunc("/dl/", DownloadHandler)
+		mux.HandleFunc("/api/ls", ListHandler)
+	}
+	mux.HandleFunc("/api/mode", ModeHandler)
+	mux.HandleFunc("/peers", peersHandler)
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		http.FileServerFS(public.Fs).ServeHTTP(w, r)
 	})
@@ -174,7 +459,32 @@ func StartServer() error {
 	}
 	_, port, _ := net.SplitHostPort(listener.Addr().String())
 	host, _ := os.Hostname()
-	fmt.Printf("http://%s:%s\nhttp://%s:%s\n", ip, port, host, port)
+
+	if !config.NoTLS {
+		cert, fingerprint, err := tlscert.Load(config.Cert, config.Key, []string{"127.0.0.1", "::1", ip, host})
+		if err != nil {
+			return fmt.Errorf("failed to prepare TLS certificate: %v", err)
+		}
+		server.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+		fmt.Printf("https://%s:%s\nhttps://%s:%s\ncode phrase: %s\ntls fingerprint: %s\n", ip, port, host, port, codePhrase, fingerprint)
+	} else {
+		fmt.Printf("http://%s:%s\nhttp://%s:%s\ncode phrase: %s\n", ip, port, host, port, codePhrase)
+	}
+
+	// Answer LAN discovery probes for this portal until shutdown.
+	discoveryCtx, cancelDiscovery := context.WithCancel(context.Background())
+	defer cancelDiscovery()
+	if !config.NoDiscovery {
+		portNum, err := strconv.Atoi(port)
+		if err != nil {
+			return fmt.Errorf("failed to parse listener port: %v", err)
+		}
+		go func() {
+			if err := discovery.Listen(discoveryCtx, config.DiscoveryAddr, portNum, discovery.HashCodePhrase(codePhrase)); err != nil {
+				log.Error("discovery listener stopped", "err", err)
+			}
+		}()
+	}
 
 	// Signal handling for graceful shutdown
 	quit := make(chan os.Signal, 1)
@@ -183,6 +493,7 @@ func StartServer() error {
 	go func() {
 		<-quit
 		fmt.Println("Shutting down server...")
+		cancelDiscovery()
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 		if err := server.Shutdown(ctx); err != nil {
@@ -191,9 +502,28 @@ func StartServer() error {
 	}()
 
 	// Start the server
+	if !config.NoTLS {
+		return server.ServeTLS(listener, "", "")
+	}
 	return server.Serve(listener)
 }
 
+// peersHandler probes the LAN for nearby portals and returns them as JSON
+// so the embedded UI can render a "nearby portals" list.
+func peersHandler(w http.ResponseWriter, r *http.Request) {
+	peers, err := discovery.Probe(config.DiscoveryAddr, time.Second)
+	if err != nil {
+		log.Error("failed to probe for peers", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(peers); err != nil {
+		log.Error("failed to encode peers", "err", err)
+	}
+}
+
 // getLocalIP retrieves the local IP address of the computer.
 func getLocalIP() (string, error) {
 	interfaces, err := net.Interfaces()
@@ -0,0 +1,161 @@
+// Package tlscert provides the self-signed TLS certificate portal
+// serves HTTPS with when the user hasn't supplied their own via
+// --cert/--key.
+package tlscert
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// validity is how long a generated certificate is valid for. It is kept
+// short since Load generates a new one once the cached one expires, and a
+// short window limits the damage if the cache directory is ever shared.
+const validity = 24 * time.Hour
+
+// Load returns a TLS certificate and its colon-separated SHA-256
+// fingerprint for serving HTTPS. If certPath and keyPath are both given,
+// the certificate is loaded from disk as-is. Otherwise a cached
+// certificate under os.UserCacheDir()/portal is reused if still valid,
+// or a fresh ECDSA P-256 self-signed certificate covering hosts is
+// generated and cached there.
+func Load(certPath, keyPath string, hosts []string) (tls.Certificate, string, error) {
+	if certPath != "" && keyPath != "" {
+		cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+		if err != nil {
+			return tls.Certificate{}, "", fmt.Errorf("failed to load certificate: %w", err)
+		}
+		return cert, fingerprint(cert), nil
+	}
+
+	dir, err := cacheDir()
+	if err != nil {
+		return tls.Certificate{}, "", err
+	}
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+
+	if cert, err := tls.LoadX509KeyPair(certFile, keyFile); err == nil && stillValid(cert) {
+		return cert, fingerprint(cert), nil
+	}
+
+	certPEM, keyPEM, err := generate(hosts)
+	if err != nil {
+		return tls.Certificate{}, "", err
+	}
+
+	if err := os.WriteFile(certFile, certPEM, 0o600); err != nil {
+		return tls.Certificate{}, "", fmt.Errorf("failed to cache certificate: %w", err)
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0o600); err != nil {
+		return tls.Certificate{}, "", fmt.Errorf("failed to cache key: %w", err)
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return tls.Certificate{}, "", fmt.Errorf("failed to parse generated certificate: %w", err)
+	}
+	return cert, fingerprint(cert), nil
+}
+
+func cacheDir() (string, error) {
+	base, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to determine cache directory: %w", err)
+	}
+	dir := filepath.Join(base, "portal")
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	return dir, nil
+}
+
+func stillValid(cert tls.Certificate) bool {
+	if len(cert.Certificate) == 0 {
+		return false
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return false
+	}
+	return time.Now().Before(leaf.NotAfter)
+}
+
+func generate(hosts []string) (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "portal"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(validity),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	for _, h := range hosts {
+		if h == "" {
+			continue
+		}
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create certificate: %w", err)
+	}
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to marshal private key: %w", err)
+	}
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	return certPEM, keyPEM, nil
+}
+
+// fingerprint returns the colon-separated hex SHA-256 digest of the
+// certificate's leaf, so a receiver can verify it out-of-band.
+func fingerprint(cert tls.Certificate) string {
+	if len(cert.Certificate) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256(cert.Certificate[0])
+	hexSum := hex.EncodeToString(sum[:])
+
+	var b strings.Builder
+	for i := 0; i < len(hexSum); i += 2 {
+		if i > 0 {
+			b.WriteByte(':')
+		}
+		b.WriteString(hexSum[i : i+2])
+	}
+	return b.String()
+}
@@ -1,14 +1,23 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	"os"
+	"time"
 
 	"github.com/charmbracelet/log"
 	"github.com/tsukinoko-kun/portal/internal/config"
+	"github.com/tsukinoko-kun/portal/internal/discovery"
 	"github.com/tsukinoko-kun/portal/internal/net"
 )
 
 func main() {
+	if flag.Arg(0) == "discover" {
+		discoverPeers()
+		return
+	}
+
 	if err := os.Chdir(config.Path); err != nil {
 		log.Fatal(err)
 	}
@@ -21,3 +30,21 @@ func main() {
 		log.Fatal(err)
 	}
 }
+
+// discoverPeers implements `portal discover`: it broadcasts a discovery
+// probe and prints every portal that answers.
+func discoverPeers() {
+	peers, err := discovery.Probe(config.DiscoveryAddr, 2*time.Second)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if len(peers) == 0 {
+		fmt.Println("no portals found")
+		return
+	}
+
+	for _, p := range peers {
+		fmt.Printf("%s  port %d  code phrase hash %s\n", p.Name, p.Port, p.CodePhraseHash)
+	}
+}